@@ -0,0 +1,151 @@
+package libsmtp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// SetFrom sets the message's From address. name may be empty for a bare
+// address.
+func (m *MailMessage) SetFrom(name, addr string) {
+	m.fromAddr = mail.Address{Name: name, Address: addr}
+}
+
+// AddTo adds a primary recipient, included in the To header. name may be
+// empty for a bare address. If addr already appears in the To list (as it
+// would after New, which populates To from its to []string parameter),
+// its display name is updated in place rather than adding a duplicate
+// recipient.
+func (m *MailMessage) AddTo(name, addr string) {
+	m.toAddrs = upsertAddress(m.toAddrs, name, addr)
+}
+
+// AddCc adds a carbon-copy recipient: it appears in the Cc header and
+// receives the message. name may be empty for a bare address. If addr
+// already appears in the Cc list, its display name is updated in place
+// rather than adding a duplicate recipient.
+func (m *MailMessage) AddCc(name, addr string) {
+	m.ccAddrs = upsertAddress(m.ccAddrs, name, addr)
+}
+
+// AddBcc adds a blind carbon-copy recipient. It receives the message via
+// SMTP RCPT but never appears in any header build() writes. If addr
+// already appears in the Bcc list, its display name is updated in place
+// rather than adding a duplicate recipient.
+func (m *MailMessage) AddBcc(name, addr string) {
+	m.bccAddrs = upsertAddress(m.bccAddrs, name, addr)
+}
+
+// upsertAddress updates the display name of the existing entry in list
+// matching addr (case-insensitively, per RFC 5321 the local part is
+// technically case-sensitive but no mail system in practice relies on
+// that), or appends a new entry if addr isn't present yet.
+func upsertAddress(list []mail.Address, name, addr string) []mail.Address {
+	for i, a := range list {
+		if strings.EqualFold(a.Address, addr) {
+			list[i].Name = name
+			return list
+		}
+	}
+
+	return append(list, mail.Address{Name: name, Address: addr})
+}
+
+// SetReplyTo sets the Reply-To header.
+func (m *MailMessage) SetReplyTo(name, addr string) {
+	m.replyTo = &mail.Address{Name: name, Address: addr}
+}
+
+// AddHeader adds an extra header, written after the address, Subject,
+// Date and Message-Id headers build() generates and before MIME-Version
+// and the body's Content-Type. key and value must not
+// contain CR or LF: build() writes them verbatim, so an embedded
+// "\r\n" would let a caller inject arbitrary extra header lines
+// (including a second Bcc) into the message.
+func (m *MailMessage) AddHeader(key, value string) error {
+	if strings.ContainsAny(key, "\r\n") || strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("libsmtp: header key/value must not contain CR or LF")
+	}
+
+	m.extraHeaders = append(m.extraHeaders, [2]string{key, value})
+	return nil
+}
+
+// rcpts returns every address that should receive the message over SMTP
+// RCPT: To, Cc, and Bcc, deduplicated (case-insensitively) across all
+// three so an address appearing in more than one list is only RCPT'd
+// once.
+func (m *MailMessage) rcpts() []string {
+	out := make([]string, 0, len(m.toAddrs)+len(m.ccAddrs)+len(m.bccAddrs))
+	seen := make(map[string]bool, cap(out))
+
+	for _, list := range [][]mail.Address{m.toAddrs, m.ccAddrs, m.bccAddrs} {
+		for _, a := range list {
+			key := strings.ToLower(a.Address)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, a.Address)
+		}
+	}
+
+	return out
+}
+
+// fromDomain returns the domain part of the From address, or "localhost"
+// if none is set, for use in a generated Message-ID.
+func (m *MailMessage) fromDomain() string {
+	parts := strings.SplitN(m.fromAddr.Address, "@", 2)
+	if len(parts) == 2 && parts[1] != "" {
+		return parts[1]
+	}
+	return "localhost"
+}
+
+// generateMessageID returns a Message-ID header value using domain.
+func generateMessageID(domain string) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("<libsmtp@%s>", domain)
+	}
+	return fmt.Sprintf("<%x@%s>", b, domain)
+}
+
+// encodeAddressList renders a comma-separated RFC 5322 address list,
+// RFC 2047 encoding any non-ASCII display names.
+func encodeAddressList(addrs []mail.Address) string {
+	parts := make([]string, len(addrs))
+	for i, a := range addrs {
+		parts[i] = encodeAddress(a)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// encodeAddress renders a single RFC 5322 address, RFC 2047 encoding the
+// display name if it contains non-ASCII characters. mail.Address.String
+// already handles quoting for ASCII names; an encoded-word name is
+// written as-is ahead of the bracketed address instead, since it must
+// not be quoted.
+func encodeAddress(a mail.Address) string {
+	if a.Name == "" {
+		return a.Address
+	}
+
+	if isASCII(a.Name) {
+		return a.String()
+	}
+
+	return fmt.Sprintf("%s <%s>", encodeHeaderWord(a.Name), a.Address)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] > 127 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package libsmtp
+
+import (
+	"fmt"
+	"net/smtp"
+)
+
+// ConnectionError wraps failures dialing or talking to the SMTP server at
+// the transport level.
+type ConnectionError struct {
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("libsmtp: connection error: %s", e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// TLSError wraps failures negotiating or verifying a TLS session with the
+// SMTP server, whether implicit or via STARTTLS.
+type TLSError struct {
+	Err error
+}
+
+func (e *TLSError) Error() string {
+	return fmt.Sprintf("libsmtp: TLS error: %s", e.Err)
+}
+
+func (e *TLSError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError wraps failures authenticating with the SMTP server, including
+// the library's own refusal to send credentials over an unencrypted
+// connection.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("libsmtp: auth error: %s", e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// DeliveryError wraps failures submitting the envelope or message body
+// once the connection has been authenticated.
+type DeliveryError struct {
+	Err error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("libsmtp: delivery error: %s", e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// NoAuth is a sentinel smtp.Auth that performs no authentication. Pass it
+// to SetAuth to explicitly opt out of AUTH, as distinct from never calling
+// SetAuth at all.
+var NoAuth smtp.Auth = noAuth{}
+
+type noAuth struct{}
+
+func (noAuth) Start(*smtp.ServerInfo) (string, []byte, error) {
+	return "", nil, nil
+}
+
+func (noAuth) Next([]byte, bool) ([]byte, error) {
+	return nil, nil
+}
+
+// plainAuth marks an smtp.Auth as AUTH PLAIN, whose credentials are sent
+// in the clear and therefore require an encrypted connection unless the
+// caller opts in via AllowUnencryptedAuth.
+type plainAuth struct {
+	smtp.Auth
+}
+
+// PlainAuth returns an smtp.Auth implementing AUTH PLAIN. Send refuses to
+// use it over an unencrypted connection unless AllowUnencryptedAuth has
+// been set.
+func PlainAuth(identity, username, password, host string) smtp.Auth {
+	return plainAuth{smtp.PlainAuth(identity, username, password, host)}
+}
+
+// unencryptedAuth wraps an smtp.Auth and reports to it that the connection
+// is TLS-protected even when it isn't, so that net/smtp's own "unencrypted
+// connection" guard doesn't refuse to start the exchange. It only takes
+// effect when the caller has explicitly set AllowUnencryptedAuth.
+type unencryptedAuth struct {
+	smtp.Auth
+}
+
+func (a unencryptedAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	s := *server
+	s.TLS = true
+	return a.Auth.Start(&s)
+}
+
+// loginAuth implements the AUTH LOGIN mechanism used by Microsoft Exchange
+// and Office 365, which net/smtp does not provide.
+type loginAuth struct {
+	username string
+	password string
+}
+
+// LoginAuth returns an smtp.Auth implementing AUTH LOGIN.
+func LoginAuth(username, password string) smtp.Auth {
+	return &loginAuth{username: username, password: password}
+}
+
+func (a *loginAuth) Start(*smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch string(fromServer) {
+	case "Username:":
+		return []byte(a.username), nil
+	case "Password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("libsmtp: unexpected AUTH LOGIN server prompt: %q", fromServer)
+	}
+}
+
+// CRAMMD5Auth returns an smtp.Auth implementing the CRAM-MD5 challenge
+// response mechanism, safe to use over an unencrypted connection since
+// the secret itself is never sent.
+func CRAMMD5Auth(username, secret string) smtp.Auth {
+	return smtp.CRAMMD5Auth(username, secret)
+}
+
+// SetAuth configures the credentials used to authenticate with the SMTP
+// server. Use PlainAuth, LoginAuth, CRAMMD5Auth, or NoAuth to build auth.
+func (m *MailMessage) SetAuth(auth smtp.Auth) {
+	m.auth = auth
+}
+
+// AllowUnencryptedAuth permits PlainAuth to be used over a connection that
+// is not TLS-protected. Off by default: sending PlainAuth credentials in
+// the clear over a connection that failed or never attempted STARTTLS is
+// a credential leak, not a convenience.
+func (m *MailMessage) AllowUnencryptedAuth(allow bool) {
+	m.allowUnencryptedAuth = allow
+}
+
+// resolveAuthFor enforces that PlainAuth credentials are never sent in
+// the clear unless the caller has explicitly opted in. It is called from
+// Dialer.dial against the TLS state of the connection it just
+// established.
+func resolveAuthFor(auth smtp.Auth, tlsActive, allowUnencryptedAuth bool) (smtp.Auth, error) {
+	if auth == nil || auth == NoAuth {
+		return nil, nil
+	}
+
+	pa, ok := auth.(plainAuth)
+	if !ok {
+		return auth, nil
+	}
+
+	if tlsActive {
+		return pa.Auth, nil
+	}
+
+	if !allowUnencryptedAuth {
+		return nil, fmt.Errorf("refusing to send PlainAuth credentials over an unencrypted connection; set AllowUnencryptedAuth(true) to override")
+	}
+
+	return unencryptedAuth{pa.Auth}, nil
+}
@@ -0,0 +1,255 @@
+package libsmtp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Dialer holds the transport configuration needed to open SMTP
+// connections, separate from any particular MailMessage. Unlike
+// MailMessage.Send, which dials once per message, a Dialer can send many
+// messages over a single reused connection via Dial, or do both in one
+// call via DialAndSend.
+type Dialer struct {
+	Host      string
+	Port      int
+	Username  string
+	Password  string
+	Auth      smtp.Auth
+	TLSMode   TLSMode
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+	LocalName string
+
+	allowUnencryptedAuth bool
+}
+
+// NewDialer returns a Dialer for the given host and port, authenticating
+// with username/password via PlainAuth. It uses TLSAuto: implicit TLS on
+// port 465, and mandatory STARTTLS on any other port.
+func NewDialer(host string, port int, username, password string) *Dialer {
+	return &Dialer{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+		Auth:     PlainAuth("", username, password, host),
+		TLSMode:  TLSAuto,
+	}
+}
+
+// AllowUnencryptedAuth permits PlainAuth to be used over a connection
+// that is not TLS-protected; see MailMessage.AllowUnencryptedAuth.
+func (d *Dialer) AllowUnencryptedAuth(allow bool) {
+	d.allowUnencryptedAuth = allow
+}
+
+// SendCloser sends messages over an already-established SMTP connection.
+// Close ends the session with QUIT.
+type SendCloser interface {
+	Send(m *MailMessage) error
+	Close() error
+}
+
+// SendFunc adapts a plain function to look like a SendCloser's Send
+// method, so callers can inject a fake transport in tests without
+// standing up a real SMTP server.
+type SendFunc func(m *MailMessage) error
+
+func (f SendFunc) Send(m *MailMessage) error { return f(m) }
+func (f SendFunc) Close() error              { return nil }
+
+// dialerConn is the Dialer's own SendCloser: it keeps a single SMTP
+// connection open across sends, issuing RSET between messages, and
+// transparently reconnecting if the server has closed the socket (a
+// common outcome of server-side idle timeouts in long-lived processes).
+type dialerConn struct {
+	dialer *Dialer
+	client *smtp.Client
+}
+
+func (d *Dialer) addr() string {
+	if strings.Contains(d.Host, ":") {
+		return d.Host
+	}
+	return fmt.Sprintf("%s:%d", d.Host, d.Port)
+}
+
+func (d *Dialer) dial() (*smtp.Client, error) {
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	mode := d.resolvedTLSMode()
+	tlsActive := false
+
+	var conn net.Conn
+	var err error
+
+	if mode == TLSImplicit {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", d.addr(), d.tlsConfigOrDefault())
+		if err != nil {
+			return nil, &TLSError{Err: err}
+		}
+
+		tlsActive = true
+	} else {
+		conn, err = net.DialTimeout("tcp", d.addr(), timeout)
+		if err != nil {
+			return nil, &ConnectionError{Err: err}
+		}
+	}
+
+	c, err := smtp.NewClient(conn, d.Host)
+	if err != nil {
+		conn.Close()
+		return nil, &ConnectionError{Err: err}
+	}
+
+	if d.LocalName != "" {
+		if err = c.Hello(d.LocalName); err != nil {
+			c.Close()
+			return nil, &ConnectionError{Err: err}
+		}
+	}
+
+	if mode == TLSStartTLS {
+		ok, _ := c.Extension("STARTTLS")
+		if !ok {
+			c.Close()
+			return nil, &TLSError{Err: fmt.Errorf("server does not advertise STARTTLS")}
+		}
+
+		if err = c.StartTLS(d.tlsConfigOrDefault()); err != nil {
+			c.Close()
+			return nil, &TLSError{Err: err}
+		}
+
+		tlsActive = true
+	}
+
+	auth, err := resolveAuthFor(d.Auth, tlsActive, d.allowUnencryptedAuth)
+	if err != nil {
+		c.Close()
+		return nil, &AuthError{Err: err}
+	}
+
+	if auth != nil {
+		if err = c.Auth(auth); err != nil {
+			c.Close()
+			return nil, &AuthError{Err: err}
+		}
+	}
+
+	return c, nil
+}
+
+// Dial opens a connection to the configured SMTP server and returns a
+// SendCloser that can send many messages over it.
+func (d *Dialer) Dial() (SendCloser, error) {
+	c, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	return &dialerConn{dialer: d, client: c}, nil
+}
+
+// DialAndSend opens a connection, sends each message in turn, and closes
+// the connection.
+func (d *Dialer) DialAndSend(msgs ...*MailMessage) error {
+	sc, err := d.Dial()
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+
+	for _, m := range msgs {
+		if err := sc.Send(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Send transmits m over the open connection, issuing RSET between
+// messages, and reconnecting once if the server has closed the socket
+// since the last send.
+func (c *dialerConn) Send(m *MailMessage) error {
+	if err := m.build(); err != nil {
+		return err
+	}
+
+	if err := c.sendOnce(m); err != nil {
+		if !isClosedConnError(err) {
+			return err
+		}
+
+		nc, dialErr := c.dialer.dial()
+		if dialErr != nil {
+			return dialErr
+		}
+
+		c.client.Close()
+		c.client = nc
+
+		return c.sendOnce(m)
+	}
+
+	return nil
+}
+
+func (c *dialerConn) sendOnce(m *MailMessage) error {
+	if err := c.client.Reset(); err != nil {
+		return &DeliveryError{Err: err}
+	}
+
+	if err := c.client.Mail(m.fromAddr.Address); err != nil {
+		return &DeliveryError{Err: err}
+	}
+
+	// To, Cc, and Bcc recipients all receive the mail via RCPT; only Bcc
+	// is withheld from the headers build() writes.
+	for _, rcpt := range m.rcpts() {
+		if err := c.client.Rcpt(rcpt); err != nil {
+			return &DeliveryError{Err: err}
+		}
+	}
+
+	w, err := c.client.Data()
+	if err != nil {
+		return &DeliveryError{Err: err}
+	}
+
+	if _, err := w.Write(m.buf.Bytes()); err != nil {
+		return &DeliveryError{Err: err}
+	}
+
+	if err := w.Close(); err != nil {
+		return &DeliveryError{Err: err}
+	}
+
+	return nil
+}
+
+// Close ends the SMTP session with QUIT.
+func (c *dialerConn) Close() error {
+	return c.client.Quit()
+}
+
+// isClosedConnError reports whether err looks like the server (or the
+// OS) tore down the connection out from under us, as opposed to a
+// protocol-level rejection that a reconnect wouldn't fix.
+func isClosedConnError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "use of closed network connection") ||
+		strings.Contains(msg, "broken pipe") ||
+		strings.Contains(msg, "EOF") ||
+		strings.Contains(msg, "connection reset by peer")
+}
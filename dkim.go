@@ -0,0 +1,243 @@
+package libsmtp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Signer is given the assembled message's header block and body, both in
+// the canonical CRLF-terminated form build() generates, and returns a
+// complete header line (e.g. "DKIM-Signature: ...") to prepend to the
+// message.
+type Signer interface {
+	Sign(headers, body []byte) (signatureHeader string, err error)
+}
+
+// SetSigner configures a Signer to run once the message has been
+// assembled. Its returned header is prepended ahead of everything else,
+// including To/Subject/MIME-Version.
+func (m *MailMessage) SetSigner(s Signer) {
+	m.signer = s
+}
+
+// applySigner runs the configured Signer, if any, against the already
+// assembled message in m.buf and prepends its signature header.
+func (m *MailMessage) applySigner() error {
+	if m.signer == nil {
+		return nil
+	}
+
+	full := m.buf.Bytes()
+
+	idx := bytes.Index(full, []byte("\r\n\r\n"))
+	if idx < 0 {
+		return fmt.Errorf("libsmtp: could not locate header/body boundary to sign")
+	}
+
+	headers := full[:idx+2]
+	body := full[idx+4:]
+
+	sigHeader, err := m.signer.Sign(headers, body)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasSuffix(sigHeader, "\r\n") {
+		sigHeader += "\r\n"
+	}
+
+	signed := make([]byte, 0, len(sigHeader)+len(full))
+	signed = append(signed, sigHeader...)
+	signed = append(signed, full...)
+
+	m.buf = bytes.NewBuffer(signed)
+
+	return nil
+}
+
+// DefaultDKIMHeaders lists the headers DKIMSigner signs when HeaderKeys
+// is left empty. Headers not present in the message are silently
+// skipped; Sign returns an error only if none of them is present.
+var DefaultDKIMHeaders = []string{"From", "To", "Subject", "Date", "Message-Id"}
+
+// DKIMSigner signs outgoing messages per RFC 6376, using
+// relaxed/relaxed canonicalization and a SHA-256 body hash. PrivateKey
+// must be an *rsa.PrivateKey (a=rsa-sha256) or an ed25519.PrivateKey
+// (a=ed25519-sha256).
+type DKIMSigner struct {
+	Domain     string
+	Selector   string
+	PrivateKey crypto.Signer
+	HeaderKeys []string
+}
+
+func (s *DKIMSigner) headerKeys() []string {
+	if len(s.HeaderKeys) > 0 {
+		return s.HeaderKeys
+	}
+	return DefaultDKIMHeaders
+}
+
+// Sign implements Signer.
+func (s *DKIMSigner) Sign(headers, body []byte) (string, error) {
+	algo, signData, err := dkimSignFunc(s.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	parsed, err := parseHeaderBlock(headers)
+	if err != nil {
+		return "", err
+	}
+
+	bodyHash := sha256.Sum256(canonicalizeBodyRelaxed(body))
+
+	var canon bytes.Buffer
+	var signedHeaders []string
+
+	for _, name := range s.headerKeys() {
+		value, ok := parsed.get(name)
+		if !ok {
+			continue
+		}
+
+		canon.WriteString(canonicalizeHeaderRelaxed(name, value))
+		signedHeaders = append(signedHeaders, name)
+	}
+
+	if len(signedHeaders) == 0 {
+		return "", fmt.Errorf("libsmtp: none of the headers to sign (%s) are present in the message", strings.Join(s.headerKeys(), ", "))
+	}
+
+	sigPrefix := fmt.Sprintf(
+		"v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		algo,
+		s.Domain,
+		s.Selector,
+		time.Now().Unix(),
+		strings.Join(signedHeaders, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]),
+	)
+
+	// the signature covers the signed headers plus this DKIM-Signature
+	// header itself, with an empty b= tag and no trailing CRLF, per
+	// RFC 6376 3.7
+	canon.WriteString(strings.TrimSuffix(canonicalizeHeaderRelaxed("DKIM-Signature", sigPrefix), "\r\n"))
+
+	sig, err := signData(canon.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	return "DKIM-Signature: " + sigPrefix + base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// dkimSignFunc returns the DKIM algorithm name and a signing function for
+// the given private key's concrete type.
+func dkimSignFunc(key crypto.Signer) (algo string, sign func([]byte) ([]byte, error), err error) {
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return "ed25519-sha256", func(data []byte) ([]byte, error) {
+			sum := sha256.Sum256(data)
+			return ed25519.Sign(k, sum[:]), nil
+		}, nil
+	case *rsa.PrivateKey:
+		return "rsa-sha256", func(data []byte) ([]byte, error) {
+			sum := sha256.Sum256(data)
+			return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+		}, nil
+	default:
+		return "", nil, fmt.Errorf("libsmtp: unsupported DKIM private key type %T", key)
+	}
+}
+
+// headerList is an ordered (name, value) list parsed from a libsmtp
+// header block, which is always one unfolded header per physical line.
+type headerList [][2]string
+
+func (h headerList) get(name string) (string, bool) {
+	for _, kv := range h {
+		if strings.EqualFold(kv[0], name) {
+			return kv[1], true
+		}
+	}
+	return "", false
+}
+
+func parseHeaderBlock(b []byte) (headerList, error) {
+	var out headerList
+
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\r\n"), "\r\n") {
+		if line == "" {
+			continue
+		}
+
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			return nil, fmt.Errorf("libsmtp: malformed header line %q", line)
+		}
+
+		out = append(out, [2]string{line[:idx], strings.TrimPrefix(line[idx+1:], " ")})
+	}
+
+	return out, nil
+}
+
+// canonicalizeHeaderRelaxed applies RFC 6376 3.4.2 relaxed header
+// canonicalization to a single header field.
+func canonicalizeHeaderRelaxed(name, value string) string {
+	name = strings.ToLower(name)
+	value = strings.TrimSpace(collapseWSP(value))
+	return name + ":" + value + "\r\n"
+}
+
+// canonicalizeBodyRelaxed applies RFC 6376 3.4.4 relaxed body
+// canonicalization: whitespace runs within a line collapse to a single
+// space, trailing whitespace on each line is removed, and trailing empty
+// lines are removed, leaving a single trailing CRLF on a non-empty body.
+func canonicalizeBodyRelaxed(body []byte) []byte {
+	lines := bytes.Split(body, []byte("\r\n"))
+
+	for i, line := range lines {
+		lines[i] = bytes.TrimRight([]byte(collapseWSP(string(line))), " \t")
+	}
+
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		return []byte{}
+	}
+
+	return append(bytes.Join(lines, []byte("\r\n")), '\r', '\n')
+}
+
+// collapseWSP reduces every run of spaces/tabs to a single space.
+func collapseWSP(s string) string {
+	var b strings.Builder
+	prevWSP := false
+
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			if !prevWSP {
+				b.WriteByte(' ')
+			}
+			prevWSP = true
+			continue
+		}
+
+		b.WriteRune(r)
+		prevWSP = false
+	}
+
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package libsmtp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+)
+
+// inlineImage is an attachment referenced from HTML body content via a
+// Content-ID, rather than listed as a regular attachment.
+type inlineImage struct {
+	data        []byte
+	contentType string
+}
+
+// SetHTMLBody sets the HTML alternative of the message body. If a text
+// body has also been set via SetTextBody, build() emits a
+// multipart/alternative part containing both; the HTML part is further
+// wrapped in multipart/related if inline images have been added.
+func (m *MailMessage) SetHTMLBody(html string) {
+	m.htmlBody = bytes.NewBufferString(html)
+}
+
+// SetTextBody sets the plain-text alternative of the message body, used
+// alongside SetHTMLBody. Unlike the SetBody content, which is sent with
+// the type set by SetContentType, it is always sent as text/plain.
+func (m *MailMessage) SetTextBody(text string) {
+	m.textBody = bytes.NewBufferString(text)
+}
+
+// AddInlineImage attaches an image referenced from the HTML body as
+// cid:<cid>, rather than as a regular attachment. pathOrReader is either
+// a file path (string) or an io.Reader supplying the image bytes. The
+// image's Content-Type is detected from its contents via
+// http.DetectContentType, falling back to an extension-based lookup when
+// a path is given and detection is inconclusive.
+func (m *MailMessage) AddInlineImage(pathOrReader interface{}, cid string) error {
+	if cid == "" {
+		return fmt.Errorf("cid required")
+	}
+
+	var data []byte
+	var name string
+
+	switch v := pathOrReader.(type) {
+	case string:
+		if v == "" {
+			return fmt.Errorf("No inline image specified")
+		}
+
+		b, err := ioutil.ReadFile(v)
+		if err != nil {
+			return err
+		}
+
+		data = b
+		name = filepath.Base(v)
+	case io.Reader:
+		b, err := ioutil.ReadAll(v)
+		if err != nil {
+			return err
+		}
+
+		data = b
+	default:
+		return fmt.Errorf("pathOrReader must be a string path or io.Reader")
+	}
+
+	contentType := http.DetectContentType(data)
+	if contentType == "application/octet-stream" && name != "" {
+		if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+			contentType = ct
+		}
+	}
+
+	m.inlineImages[cid] = inlineImage{data: data, contentType: contentType}
+
+	return nil
+}
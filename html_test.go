@@ -0,0 +1,129 @@
+package libsmtp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+func TestBuildTextHTMLAlternative(t *testing.T) {
+	m := newTestMessage(t)
+	m.SetTextBody("plain version")
+	m.SetHTMLBody("<p>html version</p>")
+
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+
+	msg, body := parseBuilt(t, raw)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", mediaType)
+	}
+
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+
+	part, err := r.NextPart()
+	if err != nil {
+		t.Fatalf("reading text part: %v", err)
+	}
+	if ct := part.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("first part Content-Type = %q, want text/plain prefix", ct)
+	}
+	textRaw, err := ioutil.ReadAll(part)
+	if err != nil {
+		t.Fatalf("reading text part body: %v", err)
+	}
+	if got, err := decodeBase64Lines(textRaw); err != nil || string(got) != "plain version" {
+		t.Errorf("text part = %q, %v, want %q", got, err, "plain version")
+	}
+
+	part, err = r.NextPart()
+	if err != nil {
+		t.Fatalf("reading html part: %v", err)
+	}
+	if ct := part.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("second part Content-Type = %q, want text/html prefix", ct)
+	}
+	htmlRaw, err := ioutil.ReadAll(part)
+	if err != nil {
+		t.Fatalf("reading html part body: %v", err)
+	}
+	if got, err := decodeBase64Lines(htmlRaw); err != nil || string(got) != "<p>html version</p>" {
+		t.Errorf("html part = %q, %v, want %q", got, err, "<p>html version</p>")
+	}
+
+	if _, err := r.NextPart(); err == nil {
+		t.Errorf("expected only two parts")
+	}
+}
+
+func TestBuildInlineImageMultipartRelated(t *testing.T) {
+	m := newTestMessage(t)
+	m.SetHTMLBody(`<img src="cid:logo">`)
+
+	imgData := []byte("\x89PNG\r\n\x1a\nfake-png-bytes")
+	if err := m.AddInlineImage(bytes.NewReader(imgData), "logo"); err != nil {
+		t.Fatalf("AddInlineImage: %v", err)
+	}
+
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+
+	msg, body := parseBuilt(t, raw)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if mediaType != "multipart/related" {
+		t.Fatalf("Content-Type = %q, want multipart/related", mediaType)
+	}
+
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+
+	part, err := r.NextPart()
+	if err != nil {
+		t.Fatalf("reading html part: %v", err)
+	}
+	if ct := part.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("first part Content-Type = %q, want text/html prefix", ct)
+	}
+
+	part, err = r.NextPart()
+	if err != nil {
+		t.Fatalf("reading image part: %v", err)
+	}
+	if got := part.Header.Get("Content-ID"); got != "<logo>" {
+		t.Errorf("Content-ID = %q, want <logo>", got)
+	}
+	if got := part.Header.Get("Content-Disposition"); got != "inline" {
+		t.Errorf("Content-Disposition = %q, want inline", got)
+	}
+
+	imgRaw, err := ioutil.ReadAll(part)
+	if err != nil {
+		t.Fatalf("reading image part body: %v", err)
+	}
+	decoded, err := decodeBase64Lines(imgRaw)
+	if err != nil {
+		t.Fatalf("decoding image body: %v", err)
+	}
+	if !bytes.Equal(decoded, imgData) {
+		t.Errorf("image contents = %q, want %q", decoded, imgData)
+	}
+
+	if _, err := r.NextPart(); err == nil {
+		t.Errorf("expected only two parts")
+	}
+}
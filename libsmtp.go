@@ -4,30 +4,40 @@ package libsmtp
 import (
 	"bytes"
 	"crypto/tls"
-	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"net/mail"
 	"net/smtp"
 	"path/filepath"
-	"strings"
 	"time"
-
-	"github.com/zerklabs/auburn/utils"
 )
 
 type MailMessage struct {
-	attachmentLengths    map[string]int
-	attachments          map[string][]byte
-	attachmentBoundaries map[string]string
-	body                 *bytes.Buffer
-	buf                  *bytes.Buffer
-	from                 string
-	port                 int
-	server               string
-	subject              string
-	tls                  bool
-	to                   []string
-	contentType          string
+	attachments  map[string][]byte
+	inlineImages map[string]inlineImage
+	body         *bytes.Buffer
+	htmlBody     *bytes.Buffer
+	textBody     *bytes.Buffer
+	buf          *bytes.Buffer
+	port         int
+	server       string
+	subject      string
+	contentType  string
+
+	fromAddr     mail.Address
+	toAddrs      []mail.Address
+	ccAddrs      []mail.Address
+	bccAddrs     []mail.Address
+	replyTo      *mail.Address
+	extraHeaders [][2]string
+
+	tlsMode   TLSMode
+	tlsConfig *tls.Config
+
+	auth                 smtp.Auth
+	allowUnencryptedAuth bool
+
+	signer Signer
 
 	buildCalled bool
 }
@@ -49,45 +59,49 @@ func New(server string, port int, from string, to []string, usetls bool) (*MailM
 		port = 25
 	}
 
+	tlsMode := TLSNone
+	if usetls {
+		tlsMode = TLSAuto
+	}
+
+	toAddrs := make([]mail.Address, len(to))
+	for i, addr := range to {
+		toAddrs[i] = mail.Address{Address: addr}
+	}
+
 	mailMessage = &MailMessage{
-		attachmentBoundaries: make(map[string]string, 0),
-		attachmentLengths:    make(map[string]int, 0),
-		attachments:          make(map[string][]byte, 0),
-		body:                 bytes.NewBuffer(nil),
-		buf:                  bytes.NewBuffer(nil),
-		contentType:          "text/plain",
-		from:                 from,
-		port:                 port,
-		server:               server,
-		subject:              fmt.Sprintf("libsmtp - %s", time.Now()),
-		tls:                  usetls,
-		to:                   to,
+		attachments:  make(map[string][]byte, 0),
+		inlineImages: make(map[string]inlineImage, 0),
+		body:         bytes.NewBuffer(nil),
+		buf:          bytes.NewBuffer(nil),
+		contentType:  "text/plain",
+		fromAddr:     mail.Address{Address: from},
+		port:         port,
+		server:       server,
+		subject:      fmt.Sprintf("libsmtp - %s", time.Now()),
+		tlsMode:      tlsMode,
+		toAddrs:      toAddrs,
 	}
 
 	return mailMessage, nil
 }
 
-// (optional) Given a path to a file, we will base64 encode and
-// generate a unique boundary ID for it
+// (optional) Given a path to a file, attach its raw contents. The
+// attachment is base64 encoded and wrapped in its own MIME part by
+// build(); each multipart node gets its own boundary, generated once.
 func (m *MailMessage) AddAttachment(pathToFile string) error {
-	if pathToFile != "" {
-		attachmentName := filepath.Base(pathToFile)
-		b, err := ioutil.ReadFile(pathToFile)
-		if err != nil {
-			return err
-		}
-
-		encodedLen := base64.StdEncoding.EncodedLen(len(b))
-		encodedAttachment := make([]byte, encodedLen)
-		base64.StdEncoding.Encode(encodedAttachment, b)
-
-		m.attachments[attachmentName] = encodedAttachment
-		m.attachmentLengths[attachmentName] = encodedLen
-		m.attachmentBoundaries[attachmentName] = utils.RandomBase36()
-	} else {
+	if pathToFile == "" {
 		return fmt.Errorf("No attachment specified")
 	}
 
+	attachmentName := filepath.Base(pathToFile)
+	b, err := ioutil.ReadFile(pathToFile)
+	if err != nil {
+		return err
+	}
+
+	m.attachments[attachmentName] = b
+
 	return nil
 }
 
@@ -116,51 +130,6 @@ func (m *MailMessage) Subject(subject string) {
 	m.subject = subject
 }
 
-// Creates the MIME mail message and writes it to the MailMessage buffer
-func (m *MailMessage) build() error {
-	if m.body.Len() == 0 {
-		return fmt.Errorf("Message body is empty")
-	}
-
-	// base64 encode the body
-	// write the body
-	body := m.body.Bytes()
-	encodedBodyLen := base64.StdEncoding.EncodedLen(len(body))
-	encodedBody := make([]byte, encodedBodyLen)
-	base64.StdEncoding.Encode(encodedBody, body)
-
-	m.buf.WriteString(fmt.Sprintf("To: %s\n", strings.Join(m.to, ",")))
-	m.buf.WriteString(fmt.Sprintf("Subject: %s\n", m.subject))
-	if len(m.attachments) > 0 {
-		for _, v := range m.attachmentBoundaries {
-			m.buf.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\n", v))
-			m.buf.WriteString(fmt.Sprintf("--%s\n", v))
-		}
-	}
-
-	m.buf.WriteString("Content-Transfer-Encoding: base64\n")
-	m.buf.WriteString("MIME-Version: 1.0;\n")
-	m.buf.WriteString(fmt.Sprintf("Content-Type: %s; charset=\"utf-8\";\n\n", m.contentType))
-	m.buf.Write(encodedBody)
-
-	if len(m.attachments) > 0 {
-		for k, v := range m.attachmentBoundaries {
-			m.buf.WriteString(fmt.Sprintf("\n\n--%s\n", v))
-			m.buf.WriteString(fmt.Sprintf("Content-Type: application/octet-stream; name=\"%s\"\n", k))
-			m.buf.WriteString(fmt.Sprintf("Content-Description: %s\n", k))
-			m.buf.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=\"%s\"; size=%d\n", k, m.attachmentLengths[k]))
-			m.buf.WriteString("Content-Transfer-Encoding: base64\n\n")
-
-			m.buf.Write(m.attachments[k])
-			m.buf.WriteString(fmt.Sprintf("\n--%s--", v))
-		}
-	}
-
-	m.buildCalled = true
-
-	return nil
-}
-
 // Returns the entire message as a byte array
 func (m *MailMessage) Bytes() ([]byte, error) {
 	if m.buildCalled {
@@ -173,82 +142,25 @@ func (m *MailMessage) Bytes() ([]byte, error) {
 
 // Attempts to send the mail message.
 //
-// By default, if TLS is desired and the handshake fails with the server,
-// this will continue to send the mail over an unencrypted channel
+// Send builds a one-shot Dialer from the message's server/port/TLS/auth
+// settings and uses it for a single DialAndSend; use a Dialer directly to
+// reuse a connection across several messages. TLSMode controls whether
+// and how TLS is negotiated (see SetTLSMode); a failed handshake is
+// always a hard error, never a silent fallback to the clear. If SetAuth
+// has been called, Send authenticates once TLS (if any) is established;
+// PlainAuth is refused over an unencrypted connection unless
+// AllowUnencryptedAuth has been set. Returns *ConnectionError, *TLSError,
+// *AuthError, or *DeliveryError depending on which stage fails; an error
+// from building or signing the message itself is returned as-is.
 func (m *MailMessage) Send() error {
-	var smtpUri string
-
-	if err := m.build(); err != nil {
-		return err
-	}
-
-	if strings.Contains(m.server, ":") {
-		smtpUri = m.server
-	} else {
-		smtpUri = fmt.Sprintf("%s:%d", m.server, m.port)
-	}
-
-	c, err := smtp.Dial(smtpUri)
-	if err != nil {
-		return err
-	}
-
-	if m.tls {
-		// check if TLS is supported
-		if ok, _ := c.Extension("STARTTLS"); ok {
-			if err = c.StartTLS(&tls.Config{InsecureSkipVerify: true, ServerName: m.server}); err != nil {
-				c.Reset()
-				c.Quit()
-
-				return err
-			}
-		}
-	}
-
-	// set the from addr
-	if err = c.Mail(m.from); err != nil {
-		c.Reset()
-		c.Quit()
-
-		return err
+	d := &Dialer{
+		Host:                 m.server,
+		Port:                 m.port,
+		Auth:                 m.auth,
+		TLSMode:              m.tlsMode,
+		TLSConfig:            m.tlsConfig,
+		allowUnencryptedAuth: m.allowUnencryptedAuth,
 	}
 
-	// add the recipients
-	for _, v := range m.to {
-		if err = c.Rcpt(v); err != nil {
-			c.Reset()
-			c.Quit()
-
-			return err
-		}
-	}
-
-	w, err := c.Data()
-
-	if err != nil {
-		c.Reset()
-		c.Quit()
-
-		return err
-	}
-
-	_, err = w.Write(m.buf.Bytes())
-
-	if err != nil {
-		c.Reset()
-		c.Quit()
-
-		return err
-	}
-
-	if err = w.Close(); err != nil {
-		c.Reset()
-		c.Quit()
-
-		return err
-	}
-
-	c.Quit()
-
-	return nil
+	return d.DialAndSend(m)
 }
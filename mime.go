@@ -0,0 +1,249 @@
+package libsmtp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"sort"
+	"time"
+)
+
+// base64LineLen is the maximum encoded line length mandated by RFC 2045
+// section 6.8.
+const base64LineLen = 76
+
+// mimeEntity is one node of the MIME tree: either a leaf part (raw
+// content that build() base64 encodes) or a multipart container (raw
+// already holds a fully assembled, boundary-delimited child body).
+type mimeEntity struct {
+	contentType  string
+	extraHeaders [][2]string
+	raw          []byte
+	isContainer  bool
+}
+
+// leafEntity wraps unencoded content as a single MIME part.
+func leafEntity(contentType string, extraHeaders [][2]string, raw []byte) mimeEntity {
+	return mimeEntity{contentType: contentType, extraHeaders: extraHeaders, raw: raw}
+}
+
+// newMultipart assembles children into a multipart/<subtype> container,
+// generating a fresh boundary.
+func newMultipart(subtype string, children []mimeEntity) mimeEntity {
+	boundary := newBoundary()
+
+	var buf bytes.Buffer
+	for _, c := range children {
+		buf.WriteString("--" + boundary + "\r\n")
+		buf.Write(c.render())
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("--" + boundary + "--\r\n")
+
+	return mimeEntity{
+		contentType: fmt.Sprintf("multipart/%s; boundary=%q", subtype, boundary),
+		raw:         buf.Bytes(),
+		isContainer: true,
+	}
+}
+
+// render writes this entity's headers and content as it appears inside
+// its parent (or at the top of the message).
+func (e mimeEntity) render() []byte {
+	var buf bytes.Buffer
+
+	writeHeader(&buf, "Content-Type", e.contentType)
+	for _, h := range e.extraHeaders {
+		writeHeader(&buf, h[0], h[1])
+	}
+
+	if e.isContainer {
+		buf.WriteString("\r\n")
+		buf.Write(e.raw)
+	} else {
+		writeHeader(&buf, "Content-Transfer-Encoding", "base64")
+		buf.WriteString("\r\n")
+		writeBase64Wrapped(&buf, e.raw)
+	}
+
+	return buf.Bytes()
+}
+
+// htmlEntity builds the HTML body part, wrapping it in multipart/related
+// with any inline images that have been added via AddInlineImage.
+func (m *MailMessage) htmlEntity() mimeEntity {
+	html := leafEntity("text/html; charset=\"utf-8\"", nil, m.htmlBody.Bytes())
+
+	if len(m.inlineImages) == 0 {
+		return html
+	}
+
+	cids := make([]string, 0, len(m.inlineImages))
+	for cid := range m.inlineImages {
+		cids = append(cids, cid)
+	}
+	sort.Strings(cids)
+
+	children := make([]mimeEntity, 0, 1+len(cids))
+	children = append(children, html)
+
+	for _, cid := range cids {
+		img := m.inlineImages[cid]
+		children = append(children, leafEntity(img.contentType, [][2]string{
+			{"Content-ID", fmt.Sprintf("<%s>", cid)},
+			{"Content-Disposition", "inline"},
+		}, img.data))
+	}
+
+	return newMultipart("related", children)
+}
+
+// bodyEntity resolves the text/html/legacy body fields into a single
+// top-level entity: multipart/alternative when both a text and an HTML
+// body are set, the HTML entity (possibly multipart/related) when only
+// HTML is set, a plain-text part when only text is set, and the legacy
+// SetBody/ContentType pair otherwise.
+func (m *MailMessage) bodyEntity() mimeEntity {
+	hasText := m.textBody != nil && m.textBody.Len() > 0
+	hasHTML := m.htmlBody != nil && m.htmlBody.Len() > 0
+
+	switch {
+	case hasHTML && hasText:
+		return newMultipart("alternative", []mimeEntity{
+			leafEntity("text/plain; charset=\"utf-8\"", nil, m.textBody.Bytes()),
+			m.htmlEntity(),
+		})
+	case hasHTML:
+		return m.htmlEntity()
+	case hasText:
+		return leafEntity("text/plain; charset=\"utf-8\"", nil, m.textBody.Bytes())
+	default:
+		return leafEntity(fmt.Sprintf("%s; charset=\"utf-8\"", m.contentType), nil, m.body.Bytes())
+	}
+}
+
+// Creates the MIME mail message and writes it to the MailMessage buffer.
+//
+// Headers and boundaries use CRLF per RFC 5322/2046. The body is resolved
+// via bodyEntity (plain, HTML, or a text+HTML alternative with inline
+// images); when attachments are present, that entity becomes the first
+// child of an outer multipart/mixed tree alongside one part per
+// attachment. All leaf parts are base64 encoded and wrapped at 76
+// columns. If a Signer has been set via SetSigner, its signature header
+// is prepended once the canonical CRLF message has been assembled.
+func (m *MailMessage) build() error {
+	hasBody := (m.textBody != nil && m.textBody.Len() > 0) ||
+		(m.htmlBody != nil && m.htmlBody.Len() > 0) ||
+		m.body.Len() > 0
+
+	if !hasBody {
+		return fmt.Errorf("Message body is empty")
+	}
+
+	writeHeader(m.buf, "From", encodeAddress(m.fromAddr))
+	writeHeader(m.buf, "To", encodeAddressList(m.toAddrs))
+	if len(m.ccAddrs) > 0 {
+		writeHeader(m.buf, "Cc", encodeAddressList(m.ccAddrs))
+	}
+	if m.replyTo != nil {
+		writeHeader(m.buf, "Reply-To", encodeAddress(*m.replyTo))
+	}
+	writeHeader(m.buf, "Subject", encodeHeaderWord(m.subject))
+	writeHeader(m.buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(m.buf, "Message-Id", generateMessageID(m.fromDomain()))
+	for _, h := range m.extraHeaders {
+		writeHeader(m.buf, h[0], h[1])
+	}
+	writeHeader(m.buf, "MIME-Version", "1.0")
+
+	body := m.bodyEntity()
+
+	if len(m.attachments) == 0 {
+		m.buf.Write(body.render())
+	} else {
+		children := make([]mimeEntity, 0, 1+len(m.attachments))
+		children = append(children, body)
+
+		names := make([]string, 0, len(m.attachments))
+		for name := range m.attachments {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			children = append(children, leafEntity(
+				fmt.Sprintf("application/octet-stream; name=%q", name),
+				[][2]string{{"Content-Disposition", fmt.Sprintf("attachment; filename=%q", name)}},
+				m.attachments[name],
+			))
+		}
+
+		m.buf.Write(newMultipart("mixed", children).render())
+	}
+
+	if err := m.applySigner(); err != nil {
+		return err
+	}
+
+	m.buildCalled = true
+	return nil
+}
+
+// writeHeader writes a single CRLF-terminated header line.
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	buf.WriteString(": ")
+	buf.WriteString(value)
+	buf.WriteString("\r\n")
+}
+
+// writeBase64Wrapped base64 encodes data and writes it CRLF-wrapped at
+// base64LineLen columns, as required by RFC 2045.
+func writeBase64Wrapped(buf *bytes.Buffer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	for len(encoded) > 0 {
+		n := base64LineLen
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+
+		buf.WriteString(encoded[:n])
+		buf.WriteString("\r\n")
+
+		encoded = encoded[n:]
+	}
+}
+
+// newBoundary generates a boundary string the same way mime/multipart
+// does internally, without requiring a real multipart.Writer for parts
+// whose headers we need full control over.
+func newBoundary() string {
+	return multipart.NewWriter(ioutil.Discard).Boundary()
+}
+
+// maxUnencodedHeaderWord is the longest an ASCII-only header value (e.g.
+// Subject) may be before encodeHeaderWord RFC 2047 encodes it anyway, to
+// keep the rendered header line to a reasonable length.
+const maxUnencodedHeaderWord = 75
+
+// encodeHeaderWord RFC 2047 encodes a header value (e.g. Subject) if it
+// contains non-ASCII bytes or is long enough to overflow a reasonable
+// header line; short ASCII-only values are returned unchanged.
+//
+// mime.BEncoding.Encode only encodes non-ASCII input, so the long-ASCII
+// case is handled separately here.
+func encodeHeaderWord(s string) string {
+	if isASCII(s) && len(s) <= maxUnencodedHeaderWord {
+		return s
+	}
+
+	if isASCII(s) {
+		return fmt.Sprintf("=?UTF-8?B?%s?=", base64.StdEncoding.EncodeToString([]byte(s)))
+	}
+
+	return mime.BEncoding.Encode("UTF-8", s)
+}
@@ -0,0 +1,211 @@
+package libsmtp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func newTestMessage(t *testing.T) *MailMessage {
+	t.Helper()
+
+	m, err := New("smtp.example.com", 25, "sender@example.com", []string{"rcpt@example.com"}, false)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return m
+}
+
+// parseBuilt parses a built message's headers via net/mail, returning the
+// parsed message alongside its raw body bytes for further inspection.
+func parseBuilt(t *testing.T, raw []byte) (*mail.Message, []byte) {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("mail.ReadMessage: %v", err)
+	}
+
+	body, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+
+	return msg, body
+}
+
+func TestBuildPlainBody(t *testing.T) {
+	m := newTestMessage(t)
+	m.SetBody("hello, world")
+
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+
+	msg, body := parseBuilt(t, raw)
+
+	if got := msg.Header.Get("Subject"); got == "" {
+		t.Errorf("Subject header missing")
+	}
+	if got := msg.Header.Get("From"); got != "sender@example.com" {
+		t.Errorf("From = %q, want %q", got, "sender@example.com")
+	}
+	if got := msg.Header.Get("To"); got != "rcpt@example.com" {
+		t.Errorf("To = %q, want %q", got, "rcpt@example.com")
+	}
+
+	mediaType, _, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if mediaType != "text/plain" {
+		t.Errorf("Content-Type = %q, want text/plain", mediaType)
+	}
+
+	decoded, err := decodeBase64Lines(body)
+	if err != nil {
+		t.Fatalf("decoding body: %v", err)
+	}
+	if string(decoded) != "hello, world" {
+		t.Errorf("body = %q, want %q", decoded, "hello, world")
+	}
+}
+
+func TestBuildSubjectRFC2047RoundTrip(t *testing.T) {
+	m := newTestMessage(t)
+	m.SetBody("body")
+	m.Subject("café ☃")
+
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+
+	msg, _ := parseBuilt(t, raw)
+
+	dec := new(mime.WordDecoder)
+	got, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("DecodeHeader: %v", err)
+	}
+	if got != "café ☃" {
+		t.Errorf("decoded subject = %q, want %q", got, "café ☃")
+	}
+}
+
+func TestBuildLongASCIISubjectIsEncoded(t *testing.T) {
+	long := strings.Repeat("a", maxUnencodedHeaderWord+1)
+
+	m := newTestMessage(t)
+	m.SetBody("body")
+	m.Subject(long)
+
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+
+	msg, _ := parseBuilt(t, raw)
+
+	rendered := msg.Header.Get("Subject")
+	if !strings.HasPrefix(rendered, "=?UTF-8?B?") {
+		t.Fatalf("rendered Subject = %q, want an RFC 2047 encoded-word", rendered)
+	}
+
+	dec := new(mime.WordDecoder)
+	got, err := dec.DecodeHeader(rendered)
+	if err != nil {
+		t.Fatalf("DecodeHeader: %v", err)
+	}
+	if got != long {
+		t.Errorf("decoded subject = %q, want %q", got, long)
+	}
+}
+
+func TestBuildAttachmentMultipartMixed(t *testing.T) {
+	m := newTestMessage(t)
+	m.SetBody("see attached")
+
+	dir := t.TempDir()
+	path := dir + "/report.txt"
+	want := []byte("attachment contents")
+	if err := ioutil.WriteFile(path, want, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := m.AddAttachment(path); err != nil {
+		t.Fatalf("AddAttachment: %v", err)
+	}
+
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+
+	msg, body := parseBuilt(t, raw)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q, want multipart/mixed", mediaType)
+	}
+
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+
+	part, err := r.NextPart()
+	if err != nil {
+		t.Fatalf("reading first part: %v", err)
+	}
+	if ct := part.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("first part Content-Type = %q, want text/plain prefix", ct)
+	}
+
+	part, err = r.NextPart()
+	if err != nil {
+		t.Fatalf("reading attachment part: %v", err)
+	}
+	if got := part.FileName(); got != "report.txt" {
+		t.Errorf("attachment filename = %q, want report.txt", got)
+	}
+
+	raw2, err := ioutil.ReadAll(part)
+	if err != nil {
+		t.Fatalf("reading attachment part body: %v", err)
+	}
+	decoded, err := decodeBase64Lines(raw2)
+	if err != nil {
+		t.Fatalf("decoding attachment body: %v", err)
+	}
+	if !bytes.Equal(decoded, want) {
+		t.Errorf("attachment contents = %q, want %q", decoded, want)
+	}
+
+	if _, err := r.NextPart(); err == nil {
+		t.Errorf("expected only two parts")
+	}
+}
+
+// decodeBase64Lines undoes writeBase64Wrapped: CRLF-joined 76-column
+// base64 lines with no multipart boundary around them.
+func decodeBase64Lines(b []byte) ([]byte, error) {
+	var encoded strings.Builder
+
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		encoded.WriteString(strings.TrimSpace(sc.Text()))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(encoded.String())
+}
@@ -0,0 +1,77 @@
+package libsmtp
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// TLSMode selects how a Dialer establishes transport security with the
+// SMTP server.
+type TLSMode int
+
+const (
+	// TLSNone never attempts TLS; the session stays in the clear.
+	TLSNone TLSMode = iota
+	// TLSStartTLS upgrades the plaintext connection via STARTTLS. Both a
+	// server that fails to advertise STARTTLS support and a failed
+	// handshake are hard errors: the message is never sent in the clear
+	// as a fallback, so a MITM cannot downgrade the session by simply
+	// stripping STARTTLS from the EHLO response.
+	TLSStartTLS
+	// TLSImplicit dials directly into a TLS session (SMTPS), as used by
+	// submission endpoints on port 465.
+	TLSImplicit
+	// TLSAuto picks TLSImplicit for port 465 and TLSStartTLS otherwise.
+	TLSAuto
+)
+
+// resolvedTLSMode expands TLSAuto into a concrete mode based on the
+// configured port.
+func (d *Dialer) resolvedTLSMode() TLSMode {
+	if d.TLSMode == TLSAuto {
+		if d.Port == 465 {
+			return TLSImplicit
+		}
+		return TLSStartTLS
+	}
+
+	return d.TLSMode
+}
+
+// tlsConfigOrDefault returns the caller-supplied TLSConfig, or a default
+// that verifies the server's certificate against the system roots using
+// the server's hostname as the expected name.
+func (d *Dialer) tlsConfigOrDefault() *tls.Config {
+	if d.TLSConfig != nil {
+		return d.TLSConfig
+	}
+
+	return &tls.Config{ServerName: d.serverName()}
+}
+
+// serverName returns Host with any embedded ":port" (as Host carries when
+// it comes from a legacy MailMessage.Send whose server string was given
+// in "host:port" form) stripped off, since a certificate's SAN never
+// includes the port.
+func (d *Dialer) serverName() string {
+	host, _, err := net.SplitHostPort(d.Host)
+	if err != nil {
+		return d.Host
+	}
+
+	return host
+}
+
+// SetTLSMode configures how the message's one-shot Dialer establishes
+// transport security. Defaults to TLSAuto if usetls was true in New, or
+// TLSNone otherwise.
+func (m *MailMessage) SetTLSMode(mode TLSMode) {
+	m.tlsMode = mode
+}
+
+// SetTLSConfig supplies a *tls.Config used for both STARTTLS and
+// implicit TLS connections, letting callers set custom root CAs, client
+// certificates, or minimum versions instead of relying on the default.
+func (m *MailMessage) SetTLSConfig(cfg *tls.Config) {
+	m.tlsConfig = cfg
+}